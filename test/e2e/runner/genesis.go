@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tendermint/tendermint/privval"
+	"github.com/tendermint/tendermint/types"
+)
+
+// MakeGenesis constructs the genesis document for a testnet, with one
+// validator entry per ModeValidator node, using each node's configured key
+// (ed25519 or secp256k1) as its genesis pubkey.
+func MakeGenesis(testnet *Testnet) (types.GenesisDoc, error) {
+	genesis := types.GenesisDoc{
+		GenesisTime:     time.Now(),
+		ChainID:         testnet.Name,
+		ConsensusParams: types.DefaultConsensusParams(),
+	}
+	for _, node := range testnet.Nodes {
+		if node.Mode != ModeValidator {
+			continue
+		}
+		genesis.Validators = append(genesis.Validators, types.GenesisValidator{
+			Name:    node.Name,
+			Address: node.Key.PubKey().Address(),
+			PubKey:  node.Key.PubKey(),
+			Power:   1,
+		})
+	}
+	if err := genesis.ValidateAndComplete(); err != nil {
+		return genesis, fmt.Errorf("invalid genesis for testnet %q: %w", testnet.Name, err)
+	}
+	return genesis, nil
+}
+
+// MakePrivValFile writes the node's private key and an empty signing state
+// to the given paths, in whatever key format the node was configured with
+// (ed25519 or secp256k1). privval.GenFilePV always generates an ed25519
+// key, so we swap in the node's own key before saving.
+func (n Node) MakePrivValFile(keyFilePath, stateFilePath string) error {
+	pv := privval.GenFilePV(keyFilePath, stateFilePath)
+	pv.Key.PrivKey = n.Key
+	pv.Key.PubKey = n.Key.PubKey()
+	pv.Key.Address = n.Key.PubKey().Address()
+	pv.Save()
+	return nil
+}
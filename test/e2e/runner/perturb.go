@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"time"
+)
+
+// perturbationWait is how many blocks the survivors must advance past the
+// pre-perturbation baseline before a perturbation is considered settled.
+const perturbationWait = 5
+
+// Perturb applies all scheduled perturbations to the given testnet's nodes,
+// each at its configured height, against a live docker-compose deployment.
+// Before each perturbation it waits for the network to reach the scheduled
+// height, and after each one it confirms the network kept progressing and
+// the affected node eventually caught back up.
+func Perturb(ctx context.Context, testnet *Testnet) error {
+	schedule := scheduledPerturbations(testnet)
+	for _, scheduled := range schedule {
+		if err := perturbNode(ctx, testnet, scheduled.node, scheduled.perturbation); err != nil {
+			return fmt.Errorf("perturbing node %q: %w", scheduled.node.Name, err)
+		}
+	}
+	return nil
+}
+
+// scheduledPerturbation pairs a perturbation with the node it targets.
+type scheduledPerturbation struct {
+	node         *Node
+	perturbation Perturbation
+}
+
+// scheduledPerturbations flattens every node's perturbation list into a
+// single schedule and sorts it by height across the whole testnet, so
+// perturbations fire in the order the manifest actually schedules them
+// rather than draining one node's queue before moving to the next.
+func scheduledPerturbations(testnet *Testnet) []scheduledPerturbation {
+	var schedule []scheduledPerturbation
+	for _, node := range testnet.Nodes {
+		for _, perturbation := range node.Perturbations {
+			schedule = append(schedule, scheduledPerturbation{node: node, perturbation: perturbation})
+		}
+	}
+	sort.SliceStable(schedule, func(i, j int) bool {
+		return schedule[i].perturbation.Height < schedule[j].perturbation.Height
+	})
+	return schedule
+}
+
+// perturbNode waits for the network to reach the perturbation's scheduled
+// height, applies it, then waits for the rest of the network to progress
+// and for the node itself to catch back up.
+func perturbNode(ctx context.Context, testnet *Testnet, node *Node, perturbation Perturbation) error {
+	if perturbation.Height > 0 {
+		if err := waitForNetworkHeight(testnet, node, perturbation.Height, 5*time.Minute); err != nil {
+			return fmt.Errorf("waiting to reach scheduled height %v: %w", perturbation.Height, err)
+		}
+	}
+
+	baseline, err := networkHeight(testnet, node)
+	if err != nil {
+		return err
+	}
+
+	switch perturbation.Type {
+	case PerturbationDisconnect:
+		if err := dockerNetworkDisconnect(ctx, testnet.Name, node.Name); err != nil {
+			return err
+		}
+		time.Sleep(10 * time.Second)
+		if err := dockerNetworkConnect(ctx, testnet.Name, node.Name); err != nil {
+			return err
+		}
+
+	case PerturbationPause:
+		if err := dockerCompose(ctx, testnet.Name, "pause", node.Name); err != nil {
+			return err
+		}
+		time.Sleep(10 * time.Second)
+		if err := dockerCompose(ctx, testnet.Name, "unpause", node.Name); err != nil {
+			return err
+		}
+
+	case PerturbationKill:
+		if err := dockerCompose(ctx, testnet.Name, "kill", "-s", "SIGKILL", node.Name); err != nil {
+			return err
+		}
+		if err := dockerCompose(ctx, testnet.Name, "start", node.Name); err != nil {
+			return err
+		}
+
+	case PerturbationRestart:
+		if err := dockerCompose(ctx, testnet.Name, "stop", node.Name); err != nil {
+			return err
+		}
+		if err := dockerCompose(ctx, testnet.Name, "start", node.Name); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("invalid perturbation %q", perturbation.Type)
+	}
+
+	if err := waitForNetworkHeight(testnet, node, baseline+perturbationWait, 3*time.Minute); err != nil {
+		return fmt.Errorf("testnet did not progress during perturbation: %w", err)
+	}
+	if err := node.WaitFor(baseline+perturbationWait, 3*time.Minute); err != nil {
+		return fmt.Errorf("node did not catch back up: %w", err)
+	}
+	return nil
+}
+
+// networkHeight returns the current height of a surviving node in the
+// testnet (any node other than the one about to be perturbed).
+func networkHeight(testnet *Testnet, perturbed *Node) (uint64, error) {
+	for _, node := range testnet.Nodes {
+		if node.Name == perturbed.Name || node.Mode == ModeSeed {
+			continue
+		}
+		client, err := node.Client()
+		if err != nil {
+			return 0, err
+		}
+		status, err := client.Status()
+		if err != nil {
+			return 0, err
+		}
+		return uint64(status.SyncInfo.LatestBlockHeight), nil
+	}
+	return 0, nil
+}
+
+// waitForNetworkHeight waits for a surviving node in the testnet (other
+// than the one being perturbed) to reach the given height.
+func waitForNetworkHeight(testnet *Testnet, perturbed *Node, height uint64, timeout time.Duration) error {
+	for _, node := range testnet.Nodes {
+		if node.Name == perturbed.Name || node.Mode == ModeSeed {
+			continue
+		}
+		return node.WaitFor(height, timeout)
+	}
+	return nil
+}
+
+// dockerCompose runs a docker-compose command against the given testnet's
+// compose file, targeting a single service.
+func dockerCompose(ctx context.Context, testnetName string, args ...string) error {
+	cmdArgs := append([]string{"-f", fmt.Sprintf("%s/docker-compose.yml", testnetName)}, args...)
+	cmd := exec.CommandContext(ctx, "docker-compose", cmdArgs...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker-compose %v: %w (%s)", args, err, out)
+	}
+	return nil
+}
+
+// dockerNetworkDisconnect severs a node's network link, simulating a
+// partition or dropped connection.
+func dockerNetworkDisconnect(ctx context.Context, testnetName, nodeName string) error {
+	cmd := exec.CommandContext(ctx, "docker", "network", "disconnect",
+		fmt.Sprintf("%s_%s", testnetName, testnetName), fmt.Sprintf("%s_%s_1", testnetName, nodeName))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker network disconnect: %w (%s)", err, out)
+	}
+	return nil
+}
+
+// dockerNetworkConnect reattaches a node's network link after a disconnect.
+func dockerNetworkConnect(ctx context.Context, testnetName, nodeName string) error {
+	cmd := exec.CommandContext(ctx, "docker", "network", "connect",
+		fmt.Sprintf("%s_%s", testnetName, testnetName), fmt.Sprintf("%s_%s_1", testnetName, nodeName))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker network connect: %w (%s)", err, out)
+	}
+	return nil
+}
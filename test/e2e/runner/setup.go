@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// builtinImageTag is the docker image tag for nodes running the ABCI app
+// in-process, built from the repo's Dockerfile with the builtin build arg.
+func builtinImageTag(testnet *Testnet) string {
+	return testnet.Name + "-builtin"
+}
+
+// Setup brings up a testnet's docker-compose deployment: it builds any node
+// images the testnet depends on, then starts the containers. A builtin-ABCI
+// node needs a dedicated image with the app compiled in, since it can't
+// attach to the app over a socket at runtime - that image has to exist
+// before the containers are created, so it's built here as part of bring-up
+// rather than during fault injection on an already-running testnet.
+func Setup(ctx context.Context, testnet *Testnet) error {
+	if err := ensureNodeImages(ctx, testnet); err != nil {
+		return err
+	}
+	return dockerComposeUp(ctx, testnet.Name)
+}
+
+// ensureNodeImages builds the dedicated builtin-ABCI node image when the
+// testnet has a node that needs it.
+func ensureNodeImages(ctx context.Context, testnet *Testnet) error {
+	if !testnet.HasBuiltinABCI() {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "docker", "build",
+		"--tag", builtinImageTag(testnet), "--build-arg", "ABCI=builtin", ".")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("building builtin node image: %w (%s)", err, out)
+	}
+	return nil
+}
+
+// dockerComposeUp starts the testnet's docker-compose deployment. The
+// compose file is expected to reference builtinImageTag as the image for
+// any node configured with the builtin ABCI protocol.
+func dockerComposeUp(ctx context.Context, testnetName string) error {
+	cmd := exec.CommandContext(ctx, "docker-compose", "-f",
+		fmt.Sprintf("%s/docker-compose.yml", testnetName), "up", "-d")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker-compose up: %w (%s)", err, out)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"sort"
 	"strconv"
@@ -11,10 +12,19 @@ import (
 
 	"github.com/tendermint/tendermint/crypto"
 	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/crypto/secp256k1"
 	rpc "github.com/tendermint/tendermint/rpc/client"
 	rpchttp "github.com/tendermint/tendermint/rpc/client/http"
 )
 
+// randomSeed is a fixed seed for the RNG used to auto-assign proxy ports, so
+// that generated manifests are reproducible across runs.
+const randomSeed = 4827392
+
+// proxyPortBase is the first port assigned to a node's ABCI proxy when the
+// manifest does not specify one.
+const proxyPortBase = 5701
+
 // Testnet represents a single testnet
 type Testnet struct {
 	Name             string
@@ -25,10 +35,41 @@ type Testnet struct {
 	Nodes            []*Node
 }
 
+// Mode is the mode a node runs in, which determines what role it plays in
+// the network and how it is configured.
+type Mode string
+
+const (
+	ModeValidator Mode = "validator"
+	ModeFull      Mode = "full"
+	ModeLight     Mode = "light"
+	ModeSeed      Mode = "seed"
+)
+
+// PerturbationType is the kind of disruption applied to a node.
+type PerturbationType string
+
+const (
+	PerturbationDisconnect PerturbationType = "disconnect"
+	PerturbationKill       PerturbationType = "kill"
+	PerturbationPause      PerturbationType = "pause"
+	PerturbationRestart    PerturbationType = "restart"
+)
+
+// Perturbation is a scheduled disruption applied to a node at a given
+// height during a testnet run, used to exercise the network's
+// fault-tolerance.
+type Perturbation struct {
+	Type   PerturbationType
+	Height uint64
+}
+
 // Node represents a Tendermint node in a testnet
 type Node struct {
 	Name            string
+	Mode            Mode
 	Key             crypto.PrivKey
+	KeyType         string
 	IP              net.IP
 	ProxyPort       uint32
 	StartAt         uint64
@@ -38,6 +79,9 @@ type Node struct {
 	PrivvalProtocol string
 	PersistInterval uint64
 	RetainBlocks    uint64
+	Seeds           []*Node
+	PersistentPeers []*Node
+	Perturbations   []Perturbation
 }
 
 // NewTestnet creates a testnet from a manifest.
@@ -69,6 +113,28 @@ func NewTestnet(manifest Manifest) (*Testnet, error) {
 	sort.Slice(testnet.Nodes, func(i, j int) bool {
 		return strings.Compare(testnet.Nodes[i].Name, testnet.Nodes[j].Name) == -1
 	})
+	if err := testnet.assignIPs(); err != nil {
+		return nil, err
+	}
+	testnet.assignProxyPorts()
+
+	for name, nodeManifest := range manifest.Nodes {
+		node := testnet.LookupNode(name)
+		for _, seedName := range nodeManifest.Seeds {
+			seed := testnet.LookupNode(seedName)
+			if seed == nil {
+				return nil, fmt.Errorf("unknown seed %q for node %q", seedName, name)
+			}
+			node.Seeds = append(node.Seeds, seed)
+		}
+		for _, peerName := range nodeManifest.PersistentPeers {
+			peer := testnet.LookupNode(peerName)
+			if peer == nil {
+				return nil, fmt.Errorf("unknown persistent peer %q for node %q", peerName, name)
+			}
+			node.PersistentPeers = append(node.PersistentPeers, peer)
+		}
+	}
 
 	for heightStr, validators := range manifest.ValidatorUpdates {
 		height, err := strconv.Atoi(heightStr)
@@ -90,10 +156,19 @@ func NewTestnet(manifest Manifest) (*Testnet, error) {
 
 // NewNode creates a new testnet node from a node manifest.
 func NewNode(name string, nodeManifest ManifestNode) (*Node, error) {
+	keyType := nodeManifest.KeyType
+	if keyType == "" {
+		keyType = "ed25519"
+	}
+	key, err := newNodeKey(keyType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key type for node %q: %w", name, err)
+	}
 	node := &Node{
 		Name:            name,
-		Key:             ed25519.GenPrivKey(),
-		IP:              net.ParseIP(nodeManifest.IP),
+		Mode:            ModeValidator,
+		Key:             key,
+		KeyType:         keyType,
 		ProxyPort:       nodeManifest.ProxyPort,
 		StartAt:         nodeManifest.StartAt,
 		FastSync:        nodeManifest.FastSync,
@@ -103,8 +178,20 @@ func NewNode(name string, nodeManifest ManifestNode) (*Node, error) {
 		PersistInterval: 1,
 		RetainBlocks:    nodeManifest.RetainBlocks,
 	}
-	if node.IP == nil { // This is how net.ParseIP signals errors
-		return nil, fmt.Errorf("invalid IP %q for node %q", nodeManifest.IP, name)
+	if nodeManifest.IP != "" {
+		node.IP = net.ParseIP(nodeManifest.IP)
+		if node.IP == nil { // This is how net.ParseIP signals errors
+			return nil, fmt.Errorf("invalid IP %q for node %q", nodeManifest.IP, name)
+		}
+	}
+	if nodeManifest.Mode != "" {
+		node.Mode = Mode(nodeManifest.Mode)
+	}
+	for _, p := range nodeManifest.Perturb {
+		node.Perturbations = append(node.Perturbations, Perturbation{
+			Type:   PerturbationType(p.Type),
+			Height: p.Height,
+		})
 	}
 	if nodeManifest.Database != "" {
 		node.Database = nodeManifest.Database
@@ -121,6 +208,18 @@ func NewNode(name string, nodeManifest ManifestNode) (*Node, error) {
 	return node, nil
 }
 
+// newNodeKey generates a new validator private key of the given type.
+func newNodeKey(keyType string) (crypto.PrivKey, error) {
+	switch keyType {
+	case "ed25519":
+		return ed25519.GenPrivKey(), nil
+	case "secp256k1":
+		return secp256k1.GenPrivKey(), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+}
+
 // Validate validates a testnet.
 func (t Testnet) Validate() error {
 	if t.Name == "" {
@@ -137,17 +236,113 @@ func (t Testnet) Validate() error {
 			return fmt.Errorf("invalid node %q: %w", node.Name, err)
 		}
 	}
+	hasValidator := false
+	for _, node := range t.Nodes {
+		if node.Mode == ModeValidator {
+			hasValidator = true
+			break
+		}
+	}
+	if !hasValidator {
+		return errors.New("network has no validators")
+	}
+
 	for height, valUpdate := range t.ValidatorUpdates {
 		for name := range valUpdate {
-			if t.LookupNode(name) == nil {
+			node := t.LookupNode(name)
+			if node == nil {
 				return fmt.Errorf("unknown node %q for validator update at height %v", name, height)
 			}
+			if node.Mode != ModeValidator {
+				return fmt.Errorf("node %q at height %v is not a validator", name, height)
+			}
+		}
+	}
+
+	if err := t.validatePeerGraph(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validatePeerGraph checks the seed/persistent-peer references between
+// nodes: that they don't self-reference, that seed nodes and validators
+// aren't wired to each other as persistent peers, and that every non-seed
+// node has a path, directly or transitively, into the validator set.
+func (t Testnet) validatePeerGraph() error {
+	for _, node := range t.Nodes {
+		for _, seed := range node.Seeds {
+			if seed.Name == node.Name {
+				return fmt.Errorf("node %q lists itself as a seed", node.Name)
+			}
+		}
+		for _, peer := range node.PersistentPeers {
+			if peer.Name == node.Name {
+				return fmt.Errorf("node %q lists itself as a persistent peer", node.Name)
+			}
+			if node.Mode == ModeValidator && peer.Mode == ModeSeed {
+				return fmt.Errorf("validator %q lists seed node %q as a persistent peer", node.Name, peer.Name)
+			}
+			if node.Mode == ModeSeed && peer.Mode == ModeValidator {
+				return fmt.Errorf("seed node %q lists validator %q as a persistent peer", node.Name, peer.Name)
+			}
+		}
+	}
+
+	// Every non-seed node must be connected, via the undirected graph of
+	// seed/persistent-peer edges, to at least one validator - directly or
+	// by transiting through other full/light nodes.
+	for _, node := range t.Nodes {
+		if node.Mode == ModeSeed {
+			continue
+		}
+		if !t.reachesValidator(node) {
+			return fmt.Errorf("node %q cannot reach a validator via its seeds/persistent peers", node.Name)
 		}
 	}
 
 	return nil
 }
 
+// reachesValidator does a breadth-first search over the undirected graph of
+// seed/persistent-peer edges to see whether start can reach a validator.
+func (t Testnet) reachesValidator(start *Node) bool {
+	if start.Mode == ModeValidator {
+		return true
+	}
+	visited := map[string]bool{start.Name: true}
+	queue := []*Node{start}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		neighbors := append(append([]*Node{}, node.Seeds...), node.PersistentPeers...)
+		for _, other := range t.Nodes {
+			for _, peer := range other.PersistentPeers {
+				if peer.Name == node.Name {
+					neighbors = append(neighbors, other)
+				}
+			}
+			for _, seed := range other.Seeds {
+				if seed.Name == node.Name {
+					neighbors = append(neighbors, other)
+				}
+			}
+		}
+		for _, next := range neighbors {
+			if visited[next.Name] {
+				continue
+			}
+			if next.Mode == ModeValidator {
+				return true
+			}
+			visited[next.Name] = true
+			queue = append(queue, next)
+		}
+	}
+	return false
+}
+
 // Validate validates a node.
 func (n Node) Validate(testnet Testnet) error {
 	if n.Name == "" {
@@ -159,6 +354,26 @@ func (n Node) Validate(testnet Testnet) error {
 	if !testnet.IP.Contains(n.IP) {
 		return fmt.Errorf("node IP %v is not in testnet network %v", n.IP, testnet.IP)
 	}
+	switch n.Mode {
+	case ModeValidator, ModeFull, ModeLight, ModeSeed:
+	default:
+		return fmt.Errorf("invalid mode %q", n.Mode)
+	}
+	switch n.KeyType {
+	case "ed25519", "secp256k1":
+	default:
+		return fmt.Errorf("invalid key type setting %q", n.KeyType)
+	}
+	if n.Mode == ModeLight && len(n.PersistentPeers) == 0 {
+		return errors.New("light client does not have a primary/witnesses configured")
+	}
+	for _, perturbation := range n.Perturbations {
+		switch perturbation.Type {
+		case PerturbationDisconnect, PerturbationKill, PerturbationPause, PerturbationRestart:
+		default:
+			return fmt.Errorf("invalid perturbation %q", perturbation.Type)
+		}
+	}
 	if n.ProxyPort > 0 {
 		if n.ProxyPort <= 1024 {
 			return fmt.Errorf("local port %v must be >1024", n.ProxyPort)
@@ -180,7 +395,7 @@ func (n Node) Validate(testnet Testnet) error {
 		return fmt.Errorf("invalid database setting %q", n.Database)
 	}
 	switch n.ABCIProtocol {
-	case "unix", "tcp", "grpc":
+	case "unix", "tcp", "grpc", "builtin":
 	default:
 		return fmt.Errorf("invalid ABCI protocol setting %q", n.ABCIProtocol)
 	}
@@ -199,6 +414,99 @@ func (n Node) Validate(testnet Testnet) error {
 	return nil
 }
 
+// assignIPs assigns sequential IP addresses from the testnet's network to
+// any node that doesn't already have one configured in the manifest,
+// skipping the network address, gateway, and broadcast address.
+func (t *Testnet) assignIPs() error {
+	used := map[string]bool{}
+	for _, node := range t.Nodes {
+		if node.IP != nil {
+			used[node.IP.String()] = true
+		}
+	}
+
+	ip := make(net.IP, len(t.IP.IP))
+	copy(ip, t.IP.IP)
+	incIP(ip) // skip the network address, e.g. x.x.x.0
+	incIP(ip) // skip the gateway, e.g. x.x.x.1
+
+	for _, node := range t.Nodes {
+		if node.IP != nil {
+			continue
+		}
+		for {
+			if !t.IP.Contains(ip) {
+				return fmt.Errorf("ran out of IP addresses in network %v", t.IP)
+			}
+			if used[ip.String()] || isBroadcastIP(ip, t.IP) {
+				incIP(ip)
+				continue
+			}
+			break
+		}
+		assigned := make(net.IP, len(ip))
+		copy(assigned, ip)
+		node.IP = assigned
+		used[assigned.String()] = true
+		incIP(ip)
+	}
+	return nil
+}
+
+// assignProxyPorts assigns proxy ports from proxyPortBase to any node that
+// doesn't already have one configured in the manifest. Assignment order is
+// shuffled by a fixed-seed RNG so that regenerating the same manifest always
+// produces the same ports, without favoring declaration order.
+func (t *Testnet) assignProxyPorts() {
+	used := map[uint32]bool{}
+	pending := []*Node{}
+	for _, node := range t.Nodes {
+		if node.ProxyPort > 0 {
+			used[node.ProxyPort] = true
+		} else {
+			pending = append(pending, node)
+		}
+	}
+
+	candidates := make([]uint32, 0, len(pending))
+	for port := uint32(proxyPortBase); len(candidates) < len(pending); port++ {
+		if !used[port] {
+			candidates = append(candidates, port)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(randomSeed))
+	for i, j := range rng.Perm(len(candidates)) {
+		pending[i].ProxyPort = candidates[j]
+	}
+}
+
+// incIP increments an IP address in place, treating it as a big-endian
+// number (e.g. x.x.x.255 rolls over into x.x.(x+1).0).
+func incIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			break
+		}
+	}
+}
+
+// isBroadcastIP returns true if ip is the IPv4 broadcast address of network.
+func isBroadcastIP(ip net.IP, network *net.IPNet) bool {
+	ip4 := ip.To4()
+	mask := network.Mask
+	if ip4 == nil || len(mask) != len(ip4) {
+		return false
+	}
+	for i := range ip4 {
+		if ip4[i]|mask[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
 // LookupNode looks up a node by name. For now, simply do a linear search.
 func (t Testnet) LookupNode(name string) *Node {
 	for _, node := range t.Nodes {
@@ -214,6 +522,17 @@ func (t Testnet) IsIPv6() bool {
 	return t.IP.IP.To4() == nil
 }
 
+// HasBuiltinABCI returns true if any node in the testnet runs the ABCI app
+// in-process rather than over a socket, requiring a dedicated node image.
+func (t Testnet) HasBuiltinABCI() bool {
+	for _, node := range t.Nodes {
+		if node.ABCIProtocol == "builtin" {
+			return true
+		}
+	}
+	return false
+}
+
 // Client returns an RPC client for a node.
 func (n Node) Client() (rpc.Client, error) {
 	return rpchttp.New(fmt.Sprintf("http://127.0.0.1:%v", n.ProxyPort), "/websocket")